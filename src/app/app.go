@@ -1,17 +1,28 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"nhooyr.io/websocket"
 )
 
+// ErrRevisionMismatch is returned by a mutation whose If-Match revision no
+// longer matches the menu's current revision.
+var ErrRevisionMismatch = errors.New("menu revision mismatch")
+
+// ErrItemNotFound is returned by PATCH when no menu item has the given id.
+var ErrItemNotFound = errors.New("menu item not found")
+
 // MenuItem captures who is bringing which dish.
 type MenuItem struct {
 	ID   string `json:"id"`
@@ -19,6 +30,25 @@ type MenuItem struct {
 	Who  string `json:"who"`
 }
 
+// MenuUpdate is a single revision of the menu as delivered to subscribers
+// that care about ordering, e.g. SSE resume and long-poll.
+type MenuUpdate struct {
+	Rev  int64
+	Data []byte
+}
+
+// historyLimit bounds the in-memory ring buffer of past revisions that SSE
+// clients can replay after a reconnect.
+const historyLimit = 50
+
+// defaultLongPollTimeout bounds how long a GET /api/menu?wait=true request
+// blocks before returning 304 Not Modified.
+const defaultLongPollTimeout = 60 * time.Second
+
+// defaultWebSocketPingInterval is how often WebSocketMenu pings idle
+// connections to keep proxies from closing them.
+const defaultWebSocketPingInterval = 30 * time.Second
+
 var defaultMenu = []MenuItem{
 	{ID: "1763786780838787402", Dish: "Turkey", Who: "Will"},
 	{ID: "1763786910210202650", Dish: "Dessert", Who: "Sarah"},
@@ -26,38 +56,116 @@ var defaultMenu = []MenuItem{
 
 // App hosts the state for the menu service.
 type App struct {
-	menuFile     string
-	mu           sync.Mutex
-	clients      map[int]chan []byte
-	clientsMu    sync.Mutex
-	nextClientID int
+	store           Store
+	mu              sync.Mutex
+	rev             int64
+	history         []MenuUpdate
+	longPollTimeout time.Duration
+	wsPingInterval  time.Duration
+	clients         map[int]chan []byte
+	revClients      map[int]chan MenuUpdate
+	clientsMu       sync.Mutex
+	nextClientID    int
+	shutdown        chan struct{}
+	closeOnce       sync.Once
+}
+
+// Option configures an App at construction time. See WithStore.
+type Option func(*App)
+
+// WithStore overrides the Store New would otherwise pick from the file
+// extension of its path argument. Mainly useful in tests that want to
+// inject a fake or pre-populated Store.
+func WithStore(store Store) Option {
+	return func(a *App) { a.store = store }
 }
 
-// New creates a menu application backed by the provided file path.
-func New(menuFile string) *App {
+// New creates a menu application backed by the store for menuFile (sniffed
+// from its extension: ".db" gets SQLite, anything else gets the JSON file
+// store), or by an explicit WithStore option.
+func New(menuFile string, opts ...Option) *App {
 	app := &App{
-		menuFile: menuFile,
-		clients:  make(map[int]chan []byte),
+		longPollTimeout: defaultLongPollTimeout,
+		wsPingInterval:  defaultWebSocketPingInterval,
+		clients:         make(map[int]chan []byte),
+		revClients:      make(map[int]chan MenuUpdate),
+		shutdown:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(app)
+	}
+	if app.store == nil {
+		store, err := newStoreForPath(menuFile)
+		if err != nil {
+			log.Printf("Failed to open store for %s, falling back to JSON file store: %v", menuFile, err)
+			store = newJSONFileStore(menuFile)
+		}
+		app.store = store
+	}
+	if _, rev, err := app.store.Load(context.Background()); err == nil {
+		app.rev = rev
 	}
-	app.ensureMenuFile()
 	return app
 }
 
+// SetLongPollTimeout overrides how long a GET /api/menu?wait=true request
+// blocks before returning 304 Not Modified. Mainly useful in tests.
+func (a *App) SetLongPollTimeout(d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.longPollTimeout = d
+}
+
+func (a *App) getLongPollTimeout() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.longPollTimeout
+}
+
+// SetWebSocketPingInterval overrides how often WebSocketMenu pings idle
+// connections. Mainly useful in tests.
+func (a *App) SetWebSocketPingInterval(d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.wsPingInterval = d
+}
+
+func (a *App) getWebSocketPingInterval() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.wsPingInterval
+}
+
+// Close unblocks every active StreamMenu and WebSocketMenu handler so a
+// caller doing a graceful shutdown (http.Server.Shutdown) doesn't have to
+// wait out those handlers' context deadlines, or the server's, before it
+// can return. Safe to call more than once.
+func (a *App) Close() {
+	a.closeOnce.Do(func() {
+		close(a.shutdown)
+	})
+}
+
 // HandleMenu exposes the REST CRUD operations for the menu.
 func (a *App) HandleMenu(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		a.handleGetMenu(w)
+		a.handleGetMenu(w, r)
 	case http.MethodPost:
 		a.handleAddMenuItem(w, r)
 	case http.MethodDelete:
 		a.handleDeleteMenuItem(w, r)
+	case http.MethodPatch:
+		a.handlePatchMenuItem(w, r)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-// StreamMenu publishes menu updates to SSE listeners.
+// StreamMenu publishes menu updates to SSE listeners. A client that
+// reconnects with a Last-Event-ID header (or a ?since= query parameter)
+// resumes from that revision instead of missing whatever changed while it
+// was offline.
 func (a *App) StreamMenu(w http.ResponseWriter, r *http.Request) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -72,28 +180,182 @@ func (a *App) StreamMenu(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", origin)
 	}
 
-	ch := make(chan []byte, 5)
-	id := a.addClient(ch)
-	defer a.removeClient(id)
-
-	menu, err := a.readMenu()
-	if err == nil {
-		data, err := json.Marshal(menu)
-		if err == nil {
-			a.sendSSE(w, flusher, data)
+	since := lastEventID(r)
+	updates, backlog, resync, cancel := a.ObserveMenuUpdatesSince(since)
+	defer cancel()
+
+	start := time.Now()
+	events := 0
+	slog.Info("sse connect", "remote_addr", r.RemoteAddr, "since", since)
+	defer func() {
+		slog.Info("sse disconnect", "remote_addr", r.RemoteAddr, "duration_ms", time.Since(start).Milliseconds(), "events", events)
+	}()
+
+	switch {
+	case resync:
+		a.sendResyncSSE(w, flusher)
+		a.sendCurrentSSE(r.Context(), w, flusher)
+		events += 2
+	case len(backlog) > 0:
+		for _, u := range backlog {
+			a.sendSSE(w, flusher, u.Rev, u.Data)
+			events++
 		}
+	default:
+		a.sendCurrentSSE(r.Context(), w, flusher)
+		events++
 	}
 
 	for {
 		select {
-		case data := <-ch:
-			a.sendSSE(w, flusher, data)
+		case u := <-updates:
+			a.sendSSE(w, flusher, u.Rev, u.Data)
+			events++
+		case <-a.shutdown:
+			return
 		case <-r.Context().Done():
 			return
 		}
 	}
 }
 
+// WebSocketMenu upgrades the connection to a WebSocket and mirrors the SSE
+// stream over it, sharing the same broadcastMenuUpdate subscriber machinery
+// so both transports see identical events. Every message, in both
+// directions, is a small {"type":"…","payload":…} envelope (see
+// wsEnvelope) rather than a bare menu array, so new event types (presence,
+// typing indicators) can be added later without breaking existing clients:
+// a full menu snapshot is sent as type "menu", and the client can push
+// mutations back over the same socket as type "add" (payload
+// {"dish":"…","who":"…"}) or type "delete" (payload {"id":"…"}) instead of a
+// separate POST/DELETE round trip. A periodic ping keeps idle proxies from
+// closing the connection.
+func (a *App) WebSocketMenu(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close(websocket.StatusInternalError, "")
+
+	ctx := r.Context()
+	updates, cancel := a.ObserveMenuUpdates()
+	defer cancel()
+
+	go a.readWebSocketMenu(ctx, conn)
+
+	if menu, _, err := a.store.Load(ctx); err == nil {
+		if data, err := json.Marshal(menu); err == nil {
+			if conn.Write(ctx, websocket.MessageText, wsEnvelopeJSON(wsEventMenu, data)) != nil {
+				return
+			}
+		}
+	}
+
+	ticker := time.NewTicker(a.getWebSocketPingInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case data, ok := <-updates:
+			if !ok {
+				conn.Close(websocket.StatusNormalClosure, "")
+				return
+			}
+			if err := conn.Write(ctx, websocket.MessageText, wsEnvelopeJSON(wsEventMenu, data)); err != nil {
+				return
+			}
+		case <-ticker.C:
+			pingCtx, cancelPing := context.WithTimeout(ctx, 10*time.Second)
+			err := conn.Ping(pingCtx)
+			cancelPing()
+			if err != nil {
+				return
+			}
+		case <-a.shutdown:
+			conn.Close(websocket.StatusGoingAway, "server shutting down")
+			return
+		case <-ctx.Done():
+			conn.Close(websocket.StatusNormalClosure, "")
+			return
+		}
+	}
+}
+
+// wsEventMenu is the envelope type for a full menu snapshot, sent whenever
+// the menu changes or a client first connects.
+const wsEventMenu = "menu"
+
+// wsEnvelope is the {"type":"…","payload":…} frame used on /api/menu/ws in
+// both directions, so new event types (presence, typing indicators) can be
+// layered on later without breaking clients that only understand "menu".
+type wsEnvelope struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// wsEnvelopeJSON wraps an already-marshaled payload in a wsEnvelope. It
+// never fails: typ and payload are always valid JSON inputs at its call
+// sites, so any marshal error here would indicate a bug, not bad input.
+func wsEnvelopeJSON(typ string, payload []byte) []byte {
+	data, err := json.Marshal(wsEnvelope{Type: typ, Payload: payload})
+	if err != nil {
+		panic(fmt.Sprintf("wsEnvelopeJSON: %v", err))
+	}
+	return data
+}
+
+// wsAddPayload is the payload of an inbound {"type":"add",...} envelope.
+type wsAddPayload struct {
+	Dish string `json:"dish"`
+	Who  string `json:"who"`
+}
+
+// wsDeletePayload is the payload of an inbound {"type":"delete",...}
+// envelope.
+type wsDeletePayload struct {
+	ID string `json:"id"`
+}
+
+// readWebSocketMenu drives the inbound half of WebSocketMenu, applying
+// mutations through the same validated paths HandleMenu uses.
+func (a *App) readWebSocketMenu(ctx context.Context, conn *websocket.Conn) {
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+
+		var env wsEnvelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			continue
+		}
+
+		switch env.Type {
+		case "add":
+			var p wsAddPayload
+			if err := json.Unmarshal(env.Payload, &p); err != nil || p.Dish == "" || p.Who == "" {
+				continue
+			}
+			item := MenuItem{
+				ID:   strconv.FormatInt(time.Now().UnixNano(), 10),
+				Dish: p.Dish,
+				Who:  p.Who,
+			}
+			if _, _, err := a.addMenuItem(ctx, item, 0, false); err != nil {
+				log.Printf("websocket add failed: %v", err)
+			}
+		case "delete":
+			var p wsDeletePayload
+			if err := json.Unmarshal(env.Payload, &p); err != nil || p.ID == "" {
+				continue
+			}
+			if _, _, err := a.deleteMenuItem(ctx, p.ID, 0, false); err != nil {
+				log.Printf("websocket delete failed: %v", err)
+			}
+		}
+	}
+}
+
 // ObserveMenuUpdates returns a buffered channel that receives menu payloads.
 func (a *App) ObserveMenuUpdates() (<-chan []byte, func()) {
 	ch := make(chan []byte, 5)
@@ -101,19 +363,144 @@ func (a *App) ObserveMenuUpdates() (<-chan []byte, func()) {
 	return ch, func() { a.removeClient(id) }
 }
 
-func (a *App) handleGetMenu(w http.ResponseWriter) {
-	menu, err := a.readMenu()
+// ObserveMenuUpdatesSince subscribes to future menu updates and, if rev is
+// still retained in the history ring buffer, returns the snapshots the
+// caller missed since that revision. If rev predates everything retained,
+// resync is true and the caller should treat the subscription as a full
+// resync rather than trust the (empty) backlog.
+//
+// It registers the subscription before taking the backlog/resync snapshot,
+// the same order WebSocketMenu uses via ObserveMenuUpdates: an update
+// broadcast in between is then guaranteed to land in either the snapshot
+// or the channel (possibly both, which is harmless since callers just
+// re-apply the same revision), never neither.
+func (a *App) ObserveMenuUpdatesSince(rev int64) (ch <-chan MenuUpdate, backlog []MenuUpdate, resync bool, cancel func()) {
+	updates := make(chan MenuUpdate, 5)
+	id := a.addRevClient(updates)
+
+	a.mu.Lock()
+	switch {
+	case rev <= 0 || rev >= a.rev:
+		// Fresh subscriber, or already caught up: nothing to replay.
+	case len(a.history) > 0 && rev >= a.history[0].Rev-1:
+		for _, u := range a.history {
+			if u.Rev > rev {
+				backlog = append(backlog, u)
+			}
+		}
+	default:
+		resync = true
+	}
+	a.mu.Unlock()
+
+	return updates, backlog, resync, func() { a.removeRevClient(id) }
+}
+
+func (a *App) handleGetMenu(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("wait") == "true" {
+		a.handleLongPollMenu(w, r)
+		return
+	}
+
+	menu, rev, err := a.store.Load(r.Context())
 	if err != nil {
 		http.Error(w, "Failed to read menu", http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("ETag", etagFor(rev))
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(menu); err != nil {
 		http.Error(w, "Failed to encode menu", http.StatusInternalServerError)
 	}
 }
 
+// handleLongPollMenu implements an etcd-v2-style wait mode for clients that
+// can't use SSE or WebSockets: it blocks until the menu's revision exceeds
+// index (or, if index is omitted, the current revision), then returns the
+// new snapshot with its revision in X-Menu-Index. It returns 304 Not
+// Modified if nothing changes before the long-poll timeout or the client
+// disconnects.
+func (a *App) handleLongPollMenu(w http.ResponseWriter, r *http.Request) {
+	index, err := parseMenuIndex(r)
+	if err != nil {
+		http.Error(w, "Invalid index", http.StatusBadRequest)
+		return
+	}
+	if index <= 0 {
+		index = a.currentRevision()
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), a.getLongPollTimeout())
+	defer cancel()
+
+	updates, backlog, resync, cancelSub := a.ObserveMenuUpdatesSince(index)
+	defer cancelSub()
+
+	switch {
+	case resync:
+		menu, rev, err := a.store.Load(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to read menu", http.StatusInternalServerError)
+			return
+		}
+		data, err := json.Marshal(menu)
+		if err != nil {
+			http.Error(w, "Failed to encode menu", http.StatusInternalServerError)
+			return
+		}
+		a.respondMenuIndex(w, rev, data)
+	case len(backlog) > 0:
+		latest := backlog[len(backlog)-1]
+		a.respondMenuIndex(w, latest.Rev, latest.Data)
+	default:
+		select {
+		case u := <-updates:
+			a.respondMenuIndex(w, u.Rev, u.Data)
+		case <-a.shutdown:
+			w.WriteHeader(http.StatusNotModified)
+		case <-ctx.Done():
+			w.WriteHeader(http.StatusNotModified)
+		}
+	}
+}
+
+func parseMenuIndex(r *http.Request) (int64, error) {
+	raw := r.URL.Query().Get("index")
+	if raw == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(raw, 10, 64)
+}
+
+// etagFor renders a revision as the ETag clients should send back in
+// If-Match, e.g. W/"r42".
+func etagFor(rev int64) string {
+	return fmt.Sprintf(`W/"r%d"`, rev)
+}
+
+// parseIfMatch extracts the revision a client expects from an If-Match
+// header shaped like W/"r42". ok is false when the header is absent, since
+// revision 0 (the menu's state before any mutation) is itself a valid
+// precondition and can't double as a sentinel.
+func parseIfMatch(r *http.Request) (rev int64, ok bool, err error) {
+	raw := r.Header.Get("If-Match")
+	if raw == "" {
+		return 0, false, nil
+	}
+	raw = strings.TrimPrefix(raw, "W/")
+	raw = strings.Trim(raw, `"`)
+	raw = strings.TrimPrefix(raw, "r")
+	rev, err = strconv.ParseInt(raw, 10, 64)
+	return rev, true, err
+}
+
+func (a *App) respondMenuIndex(w http.ResponseWriter, rev int64, data []byte) {
+	w.Header().Set("X-Menu-Index", strconv.FormatInt(rev, 10))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
 func (a *App) handleAddMenuItem(w http.ResponseWriter, r *http.Request) {
 	var newItem MenuItem
 	if err := json.NewDecoder(r.Body).Decode(&newItem); err != nil {
@@ -126,13 +513,25 @@ func (a *App) handleAddMenuItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ifMatch, hasIfMatch, err := parseIfMatch(r)
+	if err != nil {
+		http.Error(w, "Invalid If-Match header", http.StatusBadRequest)
+		return
+	}
+
 	newItem.ID = strconv.FormatInt(time.Now().UnixNano(), 10)
-	menu, err := a.addMenuItem(newItem)
+	menu, rev, err := a.addMenuItem(r.Context(), newItem, ifMatch, hasIfMatch)
+	if err == ErrRevisionMismatch {
+		w.Header().Set("ETag", etagFor(rev))
+		http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to save menu", http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("ETag", etagFor(rev))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(menu)
@@ -145,104 +544,188 @@ func (a *App) handleDeleteMenuItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := a.deleteMenuItem(id)
+	ifMatch, hasIfMatch, err := parseIfMatch(r)
+	if err != nil {
+		http.Error(w, "Invalid If-Match header", http.StatusBadRequest)
+		return
+	}
+
+	_, rev, err := a.deleteMenuItem(r.Context(), id, ifMatch, hasIfMatch)
+	if err == ErrRevisionMismatch {
+		w.Header().Set("ETag", etagFor(rev))
+		http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to save menu", http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("ETag", etagFor(rev))
 	w.WriteHeader(http.StatusOK)
 }
 
-func (a *App) addMenuItem(newItem MenuItem) ([]MenuItem, error) {
-	menu, err := a.readMenu()
-	if err != nil {
-		return nil, err
+func (a *App) handlePatchMenuItem(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID is required", http.StatusBadRequest)
+		return
 	}
 
-	menu = append(menu, newItem)
-	data, err := a.writeMenu(menu)
-	if err != nil {
-		return nil, err
+	var patch struct {
+		Dish string `json:"dish"`
+		Who  string `json:"who"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if patch.Dish == "" && patch.Who == "" {
+		http.Error(w, "Dish or Who is required", http.StatusBadRequest)
+		return
 	}
 
-	a.broadcastMenuUpdate(data)
-	return menu, nil
-}
-
-func (a *App) deleteMenuItem(id string) ([]MenuItem, error) {
-	menu, err := a.readMenu()
+	ifMatch, hasIfMatch, err := parseIfMatch(r)
 	if err != nil {
-		return nil, err
+		http.Error(w, "Invalid If-Match header", http.StatusBadRequest)
+		return
 	}
 
-	var newMenu []MenuItem
-	for _, item := range menu {
-		if item.ID != id {
-			newMenu = append(newMenu, item)
-		}
+	menu, rev, err := a.patchMenuItem(r.Context(), id, patch.Dish, patch.Who, ifMatch, hasIfMatch)
+	switch err {
+	case ErrRevisionMismatch:
+		w.Header().Set("ETag", etagFor(rev))
+		http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+		return
+	case ErrItemNotFound:
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	case nil:
+	default:
+		http.Error(w, "Failed to save menu", http.StatusInternalServerError)
+		return
 	}
 
-	data, err := a.writeMenu(newMenu)
-	if err != nil {
-		return nil, err
-	}
+	w.Header().Set("ETag", etagFor(rev))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(menu)
+}
 
-	a.broadcastMenuUpdate(data)
-	return newMenu, nil
+func (a *App) addMenuItem(ctx context.Context, newItem MenuItem, ifMatch int64, hasIfMatch bool) ([]MenuItem, int64, error) {
+	return a.mutateMenu(ctx, ifMatch, hasIfMatch, func(menu []MenuItem) ([]MenuItem, error) {
+		return append(menu, newItem), nil
+	})
 }
 
-func (a *App) readMenu() ([]MenuItem, error) {
-	a.mu.Lock()
-	defer a.mu.Unlock()
+func (a *App) deleteMenuItem(ctx context.Context, id string, ifMatch int64, hasIfMatch bool) ([]MenuItem, int64, error) {
+	return a.mutateMenu(ctx, ifMatch, hasIfMatch, func(menu []MenuItem) ([]MenuItem, error) {
+		var newMenu []MenuItem
+		for _, item := range menu {
+			if item.ID != id {
+				newMenu = append(newMenu, item)
+			}
+		}
+		return newMenu, nil
+	})
+}
 
-	data, err := os.ReadFile(a.menuFile)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return []MenuItem{}, nil
+func (a *App) patchMenuItem(ctx context.Context, id, dish, who string, ifMatch int64, hasIfMatch bool) ([]MenuItem, int64, error) {
+	return a.mutateMenu(ctx, ifMatch, hasIfMatch, func(menu []MenuItem) ([]MenuItem, error) {
+		for i := range menu {
+			if menu[i].ID != id {
+				continue
+			}
+			if dish != "" {
+				menu[i].Dish = dish
+			}
+			if who != "" {
+				menu[i].Who = who
+			}
+			return menu, nil
 		}
-		return nil, err
-	}
+		return nil, ErrItemNotFound
+	})
+}
 
-	if len(data) == 0 {
-		return []MenuItem{}, nil
-	}
+// mutateMenu runs fn against the current menu inside a single Store.Apply
+// call, so two concurrent mutations can't clobber each other the way a
+// dropped lock between read and write would allow. If hasIfMatch is set,
+// the mutation is rejected with ErrRevisionMismatch unless ifMatch equals
+// the revision Store.Apply saw when it ran fn.
+//
+// The revision/history/broadcast bookkeeping happens inside the onCommit
+// callback it passes to Apply, which Apply invokes while still holding its
+// own lock: that's what guarantees a.rev and a.history never see commits
+// out of order when two mutations race, since Apply fully serializes one
+// call (including onCommit) before the next can start.
+func (a *App) mutateMenu(ctx context.Context, ifMatch int64, hasIfMatch bool, fn func([]MenuItem) ([]MenuItem, error)) ([]MenuItem, int64, error) {
+	menu, rev, err := a.store.Apply(ctx, func(menu []MenuItem, rev int64) ([]MenuItem, error) {
+		if hasIfMatch && ifMatch != rev {
+			return nil, ErrRevisionMismatch
+		}
+		return fn(menu)
+	}, func(menu []MenuItem, rev int64) {
+		data, err := json.MarshalIndent(menu, "", "    ")
+		if err != nil {
+			log.Printf("mutateMenu: failed to marshal menu for broadcast: %v", err)
+			return
+		}
 
-	var menu []MenuItem
-	if err := json.Unmarshal(data, &menu); err != nil {
-		return nil, err
-	}
-	return menu, nil
-}
+		a.mu.Lock()
+		a.rev = rev
+		a.history = append(a.history, MenuUpdate{Rev: rev, Data: data})
+		if len(a.history) > historyLimit {
+			a.history = a.history[len(a.history)-historyLimit:]
+		}
+		a.mu.Unlock()
 
-func (a *App) writeMenu(menu []MenuItem) ([]byte, error) {
-	data, err := json.MarshalIndent(menu, "", "    ")
+		a.broadcastMenuUpdate(rev, data)
+	})
 	if err != nil {
-		return nil, err
-	}
-
-	if err := os.WriteFile(a.menuFile, data, 0644); err != nil {
-		return nil, err
+		return nil, rev, err
 	}
+	return menu, rev, nil
+}
 
-	return data, nil
+// currentRevision returns the most recently assigned revision number.
+func (a *App) currentRevision() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.rev
 }
 
-func (a *App) ensureMenuFile() {
-	if _, err := os.Stat(a.menuFile); os.IsNotExist(err) {
-		data, err := json.MarshalIndent(defaultMenu, "", "    ")
-		if err != nil {
-			log.Printf("Failed to marshal default menu: %v", err)
-			return
+// lastEventID extracts the client's last-seen revision from the standard
+// SSE Last-Event-ID header, falling back to a ?since= query parameter for
+// clients that can't set custom headers on the initial request.
+func lastEventID(r *http.Request) int64 {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		if v, err := strconv.ParseInt(id, 10, 64); err == nil {
+			return v
 		}
-		if err := os.WriteFile(a.menuFile, data, 0644); err != nil {
-			log.Printf("Failed to create default menu file: %v", err)
+	}
+	if since := r.URL.Query().Get("since"); since != "" {
+		if v, err := strconv.ParseInt(since, 10, 64); err == nil {
+			return v
 		}
 	}
+	return 0
 }
 
-func (a *App) sendSSE(w http.ResponseWriter, flusher http.Flusher, data []byte) {
+func (a *App) sendCurrentSSE(ctx context.Context, w http.ResponseWriter, flusher http.Flusher) {
+	menu, rev, err := a.store.Load(ctx)
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(menu)
+	if err != nil {
+		return
+	}
+	a.sendSSE(w, flusher, rev, data)
+}
+
+func (a *App) sendSSE(w http.ResponseWriter, flusher http.Flusher, rev int64, data []byte) {
 	fmt.Fprintf(w, "event: menu\n")
+	fmt.Fprintf(w, "id: %d\n", rev)
 	for _, line := range strings.Split(string(data), "\n") {
 		fmt.Fprintf(w, "data: %s\n", line)
 	}
@@ -250,6 +733,15 @@ func (a *App) sendSSE(w http.ResponseWriter, flusher http.Flusher, data []byte)
 	flusher.Flush()
 }
 
+// sendResyncSSE tells a client its Last-Event-ID is too old to replay from
+// history; it should discard what it has and wait for the full menu that
+// follows.
+func (a *App) sendResyncSSE(w http.ResponseWriter, flusher http.Flusher) {
+	fmt.Fprint(w, "event: resync\n")
+	fmt.Fprint(w, "data: resync\n\n")
+	flusher.Flush()
+}
+
 func (a *App) addClient(ch chan []byte) int {
 	a.clientsMu.Lock()
 	defer a.clientsMu.Unlock()
@@ -268,13 +760,40 @@ func (a *App) removeClient(id int) {
 	}
 }
 
-func (a *App) broadcastMenuUpdate(data []byte) {
+func (a *App) addRevClient(ch chan MenuUpdate) int {
+	a.clientsMu.Lock()
+	defer a.clientsMu.Unlock()
+
+	a.nextClientID++
+	a.revClients[a.nextClientID] = ch
+	return a.nextClientID
+}
+
+func (a *App) removeRevClient(id int) {
+	a.clientsMu.Lock()
+	defer a.clientsMu.Unlock()
+	if ch, ok := a.revClients[id]; ok {
+		close(ch)
+		delete(a.revClients, id)
+	}
+}
+
+func (a *App) broadcastMenuUpdate(rev int64, data []byte) {
 	a.clientsMu.Lock()
 	defer a.clientsMu.Unlock()
+
 	for _, ch := range a.clients {
 		select {
 		case ch <- data:
 		default:
 		}
 	}
+
+	update := MenuUpdate{Rev: rev, Data: data}
+	for _, ch := range a.revClients {
+		select {
+		case ch <- update:
+		default:
+		}
+	}
 }
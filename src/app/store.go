@@ -0,0 +1,296 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	_ "modernc.org/sqlite"
+)
+
+// Store persists the menu and assigns each mutation a revision. App talks to
+// storage exclusively through this interface, so the on-disk format (JSON
+// file or SQLite) can change without touching HTTP or pub/sub
+// code.
+type Store interface {
+	// Load returns the current menu and the revision it was read at.
+	Load(ctx context.Context) ([]MenuItem, int64, error)
+	// Apply runs mutator against the current menu and the revision it was
+	// read at, under the store's lock (making the read-check-write
+	// atomic), persists the result if mutator succeeds, and returns the
+	// new menu with the revision it was assigned. If mutator returns an
+	// error (e.g. ErrRevisionMismatch), Apply leaves the store untouched
+	// and returns that error alongside the revision mutator saw, so the
+	// caller can still report it in a response header.
+	//
+	// If mutator succeeds, onCommit is invoked with the new menu and
+	// revision before Apply releases its lock and before any other Apply
+	// call can start. Callers that keep their own revision-ordered state
+	// in sync with the store (e.g. App's broadcast history) must do so
+	// from onCommit: that's the only way to guarantee their updates are
+	// never observed out of order when mutations race.
+	Apply(ctx context.Context, mutator func(menu []MenuItem, rev int64) ([]MenuItem, error), onCommit func(menu []MenuItem, rev int64)) ([]MenuItem, int64, error)
+}
+
+// newStoreForPath picks a Store implementation from the file extension:
+// ".db" gets SQLite, anything else gets the JSON file store.
+func newStoreForPath(path string) (Store, error) {
+	if filepath.Ext(path) == ".db" {
+		return newSQLiteStore(path)
+	}
+	return newJSONFileStore(path), nil
+}
+
+// jsonFileStore is the original storage backend: the menu lives in memory
+// and is mirrored to a JSON file on every Apply. Writes go to a temp file
+// that is fsynced and renamed over the target, so a crash mid-write can
+// never leave a truncated or half-written menu.json behind.
+type jsonFileStore struct {
+	mu   sync.Mutex
+	path string
+	menu []MenuItem
+	rev  int64
+}
+
+// newJSONFileStore loads path if it exists and parses, or seeds it (and the
+// in-memory cache) with defaultMenu if it's missing, empty, or corrupt.
+func newJSONFileStore(path string) *jsonFileStore {
+	s := &jsonFileStore{path: path}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil && len(data) > 0:
+		var menu []MenuItem
+		if jsonErr := json.Unmarshal(data, &menu); jsonErr == nil {
+			s.menu = menu
+			return s
+		}
+		log.Printf("store: %s is corrupt, reseeding default menu", path)
+	case err != nil && !os.IsNotExist(err):
+		log.Printf("store: failed to read %s, reseeding default menu: %v", path, err)
+	}
+
+	s.menu = append([]MenuItem(nil), defaultMenu...)
+	if err := s.persistLocked(); err != nil {
+		log.Printf("store: failed to seed %s: %v", path, err)
+	}
+	return s
+}
+
+func (s *jsonFileStore) Load(ctx context.Context) ([]MenuItem, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]MenuItem(nil), s.menu...), s.rev, nil
+}
+
+func (s *jsonFileStore) Apply(ctx context.Context, mutator func([]MenuItem, int64) ([]MenuItem, error), onCommit func([]MenuItem, int64)) ([]MenuItem, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	menu, err := mutator(append([]MenuItem(nil), s.menu...), s.rev)
+	if err != nil {
+		return nil, s.rev, err
+	}
+
+	prev := s.menu
+	s.menu = menu
+	if err := s.persistLocked(); err != nil {
+		s.menu = prev
+		return nil, s.rev, err
+	}
+	s.rev++
+
+	result := append([]MenuItem(nil), s.menu...)
+	if onCommit != nil {
+		onCommit(result, s.rev)
+	}
+	return result, s.rev, nil
+}
+
+// persistLocked writes s.menu to s.path via write-temp + fsync + rename, so
+// a reader (or the next process to start up) never observes a half-written
+// file. Callers must hold s.mu.
+func (s *jsonFileStore) persistLocked() error {
+	data, err := json.MarshalIndent(s.menu, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.path)
+	tmp, err := os.CreateTemp(dir, ".menu-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // no-op once the rename below succeeds
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir fsyncs a directory so a preceding rename into it survives a crash,
+// not just the file it renamed.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// sqliteStore keeps the menu as rows in a SQLite database, giving edits and
+// the revision bump transactional all-or-nothing semantics for free.
+type sqliteStore struct {
+	mu sync.Mutex
+	db *sql.DB
+}
+
+func newSQLiteStore(path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite store: %w", err)
+	}
+	// modernc.org/sqlite serializes access to a given connection; pinning
+	// to one avoids SQLITE_BUSY from concurrent writers on the same file.
+	db.SetMaxOpenConns(1)
+
+	s := &sqliteStore{db: db}
+	if err := s.init(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *sqliteStore) init() error {
+	const schema = `
+CREATE TABLE IF NOT EXISTS menu_items (
+	id   TEXT PRIMARY KEY,
+	dish TEXT NOT NULL,
+	who  TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS revision (
+	id  INTEGER PRIMARY KEY CHECK (id = 1),
+	rev INTEGER NOT NULL
+);
+`
+	if _, err := s.db.Exec(schema); err != nil {
+		return fmt.Errorf("create schema: %w", err)
+	}
+	if _, err := s.db.Exec(`INSERT OR IGNORE INTO revision (id, rev) VALUES (1, 0)`); err != nil {
+		return fmt.Errorf("seed revision: %w", err)
+	}
+
+	var count int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM menu_items`).Scan(&count); err != nil {
+		return fmt.Errorf("count menu_items: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("seed default menu: %w", err)
+	}
+	defer tx.Rollback()
+	for _, item := range defaultMenu {
+		if _, err := tx.Exec(`INSERT INTO menu_items (id, dish, who) VALUES (?, ?, ?)`, item.ID, item.Dish, item.Who); err != nil {
+			return fmt.Errorf("seed default menu: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) Load(ctx context.Context) ([]MenuItem, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loadLocked(ctx)
+}
+
+func (s *sqliteStore) loadLocked(ctx context.Context) ([]MenuItem, int64, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, dish, who FROM menu_items ORDER BY rowid`)
+	if err != nil {
+		return nil, 0, fmt.Errorf("query menu: %w", err)
+	}
+	defer rows.Close()
+
+	menu := []MenuItem{}
+	for rows.Next() {
+		var item MenuItem
+		if err := rows.Scan(&item.ID, &item.Dish, &item.Who); err != nil {
+			return nil, 0, fmt.Errorf("scan menu item: %w", err)
+		}
+		menu = append(menu, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var rev int64
+	if err := s.db.QueryRowContext(ctx, `SELECT rev FROM revision WHERE id = 1`).Scan(&rev); err != nil {
+		return nil, 0, fmt.Errorf("query revision: %w", err)
+	}
+	return menu, rev, nil
+}
+
+func (s *sqliteStore) Apply(ctx context.Context, mutator func([]MenuItem, int64) ([]MenuItem, error), onCommit func([]MenuItem, int64)) ([]MenuItem, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	menu, rev, err := s.loadLocked(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	newMenu, err := mutator(menu, rev)
+	if err != nil {
+		return nil, rev, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, rev, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM menu_items`); err != nil {
+		return nil, rev, err
+	}
+	for _, item := range newMenu {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO menu_items (id, dish, who) VALUES (?, ?, ?)`, item.ID, item.Dish, item.Who); err != nil {
+			return nil, rev, err
+		}
+	}
+	rev++
+	if _, err := tx.ExecContext(ctx, `UPDATE revision SET rev = ? WHERE id = 1`, rev); err != nil {
+		return nil, rev, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, rev, err
+	}
+
+	if onCommit != nil {
+		onCommit(newMenu, rev)
+	}
+	return newMenu, rev, nil
+}
@@ -1,19 +1,135 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"friendsgiving/src/app"
 )
 
+// shutdownTimeout bounds how long the server waits, after receiving a
+// shutdown signal, for in-flight requests (including long-lived SSE and
+// WebSocket streams) to drain before giving up.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	menuApp := app.New("/app/data/menu.json")
-	http.HandleFunc("/api/menu", menuApp.HandleMenu)
-	http.HandleFunc("/api/menu/stream", menuApp.StreamMenu)
-	http.Handle("/", http.FileServer(http.Dir("/app/static")))
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
+	listen := flag.String("listen", defaultListenAddr(), "address to listen on (host:port)")
+	data := flag.String("data", getenvWithDefault("MENU_DATA", "/app/data/menu.json"), "path to the menu data file (.json or .db)")
+	static := flag.String("static", getenvWithDefault("STATIC_DIR", "/app/static"), "directory to serve static assets from")
+	flag.Parse()
+
+	log.Printf("starting with listen=%s data=%s static=%s", *listen, *data, *static)
+
+	menuApp := app.New(*data)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/menu", menuApp.HandleMenu)
+	mux.HandleFunc("/api/menu/stream", menuApp.StreamMenu)
+	mux.HandleFunc("/api/menu/ws", menuApp.WebSocketMenu)
+	mux.Handle("/", http.FileServer(http.Dir(*static)))
+
+	srv := &http.Server{Addr: *listen, Handler: logger(mux)}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("server error: %v", err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	log.Print("shutting down")
+	menuApp.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("graceful shutdown failed: %v", err)
+	}
+}
+
+// logger wraps h with a structured access log: remote addr, method, path,
+// status code, response size, and duration, emitted as JSON via slog so
+// operators can ship it to a collector. Long-lived connections (SSE,
+// WebSocket) only produce one line here, at disconnect, with their full
+// duration; StreamMenu additionally logs its own connect/disconnect
+// boundaries with an event count, since this middleware has no visibility
+// into what's sent over an open stream.
+func logger(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+		slog.Info("request",
+			"remote_addr", r.RemoteAddr,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	})
+}
+
+// statusRecorder wraps a ResponseWriter to capture the status code and byte
+// count written through it, forwarding Flush and Hijack so it stays
+// transparent to the SSE and WebSocket handlers behind it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("underlying ResponseWriter does not support hijacking")
+	}
+	return h.Hijack()
+}
+
+// defaultListenAddr builds the -listen flag's default from the HOST and
+// PORT environment variables, so e.g. `PORT=9000` keeps working without an
+// explicit flag.
+func defaultListenAddr() string {
+	return os.Getenv("HOST") + ":" + getenvWithDefault("PORT", "8000")
+}
 
-	fmt.Println("Server starting on http://localhost:8000")
-	log.Fatal(http.ListenAndServe(":8000", nil))
+// getenvWithDefault returns the named environment variable, or fallback if
+// it's unset or empty.
+func getenvWithDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
 }
@@ -1,6 +1,7 @@
 package app_test
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -11,6 +12,8 @@ import (
 	"testing"
 	"time"
 
+	"nhooyr.io/websocket"
+
 	"friendsgiving/src/app"
 )
 
@@ -216,12 +219,15 @@ func TestDeleteMenuItemBroadcasts(t *testing.T) {
 	}
 }
 
-func TestReadMenuHandlesMissingFile(t *testing.T) {
-	dir := t.TempDir()
-	menuPath := filepath.Join(dir, "menu.json")
+func TestStoreSurvivesExternalFileRemoval(t *testing.T) {
+	initial := []app.MenuItem{{ID: "1", Dish: "Stuffing", Who: "Pat"}}
+	initialData, _ := json.Marshal(initial)
+	menuPath := setupTestMenuFile(t, initialData)
 	server := app.New(menuPath)
 
-	// Delete the file so readMenu has to handle os.IsNotExist.
+	// The store caches the menu in memory after load, so losing the
+	// backing file out from under it (e.g. another process clobbering it)
+	// doesn't lose what's already been read.
 	if err := os.Remove(menuPath); err != nil {
 		t.Fatalf("failed to remove menu file: %v", err)
 	}
@@ -231,15 +237,28 @@ func TestReadMenuHandlesMissingFile(t *testing.T) {
 	server.HandleMenu(rr, req)
 
 	if rr.Code != http.StatusOK {
-		t.Fatalf("expected 200 when menu file is missing, got %d", rr.Code)
+		t.Fatalf("expected 200 after the backing file is removed, got %d", rr.Code)
 	}
 
 	var menu []app.MenuItem
 	if err := json.NewDecoder(rr.Body).Decode(&menu); err != nil {
 		t.Fatalf("failed to decode menu: %v", err)
 	}
-	if len(menu) != 0 {
-		t.Fatalf("expected empty menu when file missing, got %#v", menu)
+	if len(menu) != 1 || menu[0].Dish != "Stuffing" {
+		t.Fatalf("expected in-memory menu to survive file removal, got %#v", menu)
+	}
+
+	// The next mutation should recreate the file from the in-memory state.
+	addReq := httptest.NewRequest(http.MethodPost, "/api/menu", strings.NewReader(`{"dish":"Yams","who":"Ari"}`))
+	addReq.Header.Set("Content-Type", "application/json")
+	addRR := httptest.NewRecorder()
+	server.HandleMenu(addRR, addReq)
+	if addRR.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", addRR.Code)
+	}
+
+	if _, err := os.Stat(menuPath); err != nil {
+		t.Fatalf("expected menu file to be recreated after a write, got error: %v", err)
 	}
 }
 
@@ -281,6 +300,390 @@ func TestStreamMenuSendsInitialAndUpdates(t *testing.T) {
 	}
 }
 
+func TestStreamMenuIncludesRevisionID(t *testing.T) {
+	menuPath := setupTestMenuFile(t, []byte("[]"))
+	server := app.New(menuPath)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/menu/stream", nil)
+	rr := httptest.NewRecorder()
+	flushingWriter := &flushRecorder{ResponseRecorder: rr}
+
+	done := make(chan struct{})
+	go func() {
+		server.StreamMenu(flushingWriter, req)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	body := rr.Body.String()
+	if !strings.Contains(body, "id: 0") {
+		t.Fatalf("expected initial SSE frame to carry a revision id, got %q", body)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestCloseUnblocksStreamMenu(t *testing.T) {
+	menuPath := setupTestMenuFile(t, []byte("[]"))
+	server := app.New(menuPath)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/menu/stream", nil)
+	rr := httptest.NewRecorder()
+	flushingWriter := &flushRecorder{ResponseRecorder: rr}
+
+	done := make(chan struct{})
+	go func() {
+		server.StreamMenu(flushingWriter, req)
+		close(done)
+	}()
+
+	// Give StreamMenu a chance to subscribe before we close the server.
+	time.Sleep(50 * time.Millisecond)
+	server.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected StreamMenu to return promptly after App.Close()")
+	}
+}
+
+func TestCloseUnblocksWebSocketMenu(t *testing.T) {
+	menuPath := setupTestMenuFile(t, []byte("[]"))
+	server := app.New(menuPath)
+	server.SetWebSocketPingInterval(time.Hour)
+
+	ts := httptest.NewServer(http.HandlerFunc(server.WebSocketMenu))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to dial websocket: %v", err)
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	// Read the initial menu envelope so the handler has reached its
+	// select loop before we close the server.
+	if _, _, err := conn.Read(ctx); err != nil {
+		t.Fatalf("failed to read initial frame: %v", err)
+	}
+
+	server.Close()
+
+	done := make(chan struct{})
+	go func() {
+		conn.Read(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected WebSocketMenu to close the connection promptly after App.Close()")
+	}
+}
+
+func TestObserveMenuUpdatesSinceReplaysBacklog(t *testing.T) {
+	menuPath := setupTestMenuFile(t, []byte("[]"))
+	server := app.New(menuPath)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/menu", strings.NewReader("{\"dish\":\"Gravy\",\"who\":\"Sam\"}"))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	server.HandleMenu(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", rr.Code)
+	}
+
+	_, backlog, resync, cancel := server.ObserveMenuUpdatesSince(0)
+	cancel()
+	if resync {
+		t.Fatalf("rev 0 should never trigger a resync")
+	}
+	if len(backlog) != 0 {
+		t.Fatalf("expected no backlog for a fresh subscriber, got %#v", backlog)
+	}
+
+	_, backlog, resync, cancel = server.ObserveMenuUpdatesSince(1)
+	defer cancel()
+	if resync {
+		t.Fatalf("did not expect a resync when rev 1 is still retained")
+	}
+
+	req2 := httptest.NewRequest(http.MethodPost, "/api/menu", strings.NewReader("{\"dish\":\"Rolls\",\"who\":\"Pat\"}"))
+	req2.Header.Set("Content-Type", "application/json")
+	rr2 := httptest.NewRecorder()
+	server.HandleMenu(rr2, req2)
+	if rr2.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", rr2.Code)
+	}
+
+	_, backlog, resync, cancel3 := server.ObserveMenuUpdatesSince(1)
+	cancel3()
+	if resync {
+		t.Fatalf("did not expect a resync when rev 1 is still retained")
+	}
+	if len(backlog) != 1 || backlog[0].Rev != 2 {
+		t.Fatalf("expected backlog to replay revision 2, got %#v", backlog)
+	}
+}
+
+func TestObserveMenuUpdatesSinceResyncsWhenRevisionIsGone(t *testing.T) {
+	menuPath := setupTestMenuFile(t, []byte("[]"))
+	server := app.New(menuPath)
+
+	// Push enough mutations to push revision 1 out of the retained history
+	// ring buffer, then ask for it.
+	for i := 0; i < 60; i++ {
+		body := strings.NewReader(`{"dish":"Gravy","who":"Sam"}`)
+		req := httptest.NewRequest(http.MethodPost, "/api/menu", body)
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.HandleMenu(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d", rr.Code)
+		}
+	}
+
+	_, backlog, resync, cancel := server.ObserveMenuUpdatesSince(1)
+	defer cancel()
+	if !resync {
+		t.Fatalf("expected a resync when the client's revision predates everything retained")
+	}
+	if len(backlog) != 0 {
+		t.Fatalf("backlog should be ignored on resync, got %#v", backlog)
+	}
+}
+
+func TestHandleGetMenuLongPollReturnsOnChange(t *testing.T) {
+	menuPath := setupTestMenuFile(t, []byte("[]"))
+	server := app.New(menuPath)
+	server.SetLongPollTimeout(2 * time.Second)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "/api/menu?wait=true", nil)
+		rr := httptest.NewRecorder()
+		server.HandleMenu(rr, req)
+		done <- rr
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+
+	addReq := httptest.NewRequest(http.MethodPost, "/api/menu", strings.NewReader(`{"dish":"Yams","who":"Ari"}`))
+	addReq.Header.Set("Content-Type", "application/json")
+	addRR := httptest.NewRecorder()
+	server.HandleMenu(addRR, addReq)
+	if addRR.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", addRR.Code)
+	}
+
+	select {
+	case rr := <-done:
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rr.Code)
+		}
+		if rr.Header().Get("X-Menu-Index") != "1" {
+			t.Fatalf("expected X-Menu-Index 1, got %q", rr.Header().Get("X-Menu-Index"))
+		}
+		var menu []app.MenuItem
+		if err := json.Unmarshal(rr.Body.Bytes(), &menu); err != nil {
+			t.Fatalf("failed to decode long-poll response: %v", err)
+		}
+		if len(menu) != 1 || menu[0].Dish != "Yams" {
+			t.Fatalf("unexpected long-poll menu: %#v", menu)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("long-poll request did not return after a menu change")
+	}
+}
+
+func TestHandleGetMenuLongPollTimesOut(t *testing.T) {
+	menuPath := setupTestMenuFile(t, []byte("[]"))
+	server := app.New(menuPath)
+	server.SetLongPollTimeout(20 * time.Millisecond)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/menu?wait=true", nil)
+	rr := httptest.NewRecorder()
+	server.HandleMenu(rr, req)
+
+	if rr.Code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", rr.Code)
+	}
+}
+
+func TestHandleGetMenuSetsETag(t *testing.T) {
+	menuPath := setupTestMenuFile(t, []byte("[]"))
+	server := app.New(menuPath)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/menu", nil)
+	rr := httptest.NewRecorder()
+	server.HandleMenu(rr, req)
+
+	if etag := rr.Header().Get("ETag"); etag != `W/"r0"` {
+		t.Fatalf(`expected ETag W/"r0", got %q`, etag)
+	}
+}
+
+func TestHandleAddMenuItemIfMatchMismatch(t *testing.T) {
+	menuPath := setupTestMenuFile(t, []byte("[]"))
+	server := app.New(menuPath)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/menu", strings.NewReader(`{"dish":"Biscuits","who":"Lee"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", `W/"r5"`)
+	rr := httptest.NewRecorder()
+	server.HandleMenu(rr, req)
+
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412, got %d", rr.Code)
+	}
+	if etag := rr.Header().Get("ETag"); etag != `W/"r0"` {
+		t.Fatalf(`expected current ETag W/"r0", got %q`, etag)
+	}
+}
+
+func TestConcurrentDeletesOneSucceedsOneFails(t *testing.T) {
+	initial := []app.MenuItem{
+		{ID: "1", Dish: "Pumpkin Pie", Who: "Alex"},
+		{ID: "2", Dish: "Cranberry Sauce", Who: "Maya"},
+	}
+	initialData, _ := json.Marshal(initial)
+	menuPath := setupTestMenuFile(t, initialData)
+	server := app.New(menuPath)
+
+	getReq := httptest.NewRequest(http.MethodGet, "/api/menu", nil)
+	getRR := httptest.NewRecorder()
+	server.HandleMenu(getRR, getReq)
+	etag := getRR.Header().Get("ETag")
+
+	codes := make(chan int, 2)
+	del := func(id string) {
+		req := httptest.NewRequest(http.MethodDelete, "/api/menu?id="+id, nil)
+		req.Header.Set("If-Match", etag)
+		rr := httptest.NewRecorder()
+		server.HandleMenu(rr, req)
+		codes <- rr.Code
+	}
+
+	go del("1")
+	go del("2")
+
+	a, b := <-codes, <-codes
+	ok200 := 0
+	ok412 := 0
+	for _, code := range []int{a, b} {
+		switch code {
+		case http.StatusOK:
+			ok200++
+		case http.StatusPreconditionFailed:
+			ok412++
+		default:
+			t.Fatalf("unexpected status %d", code)
+		}
+	}
+	if ok200 != 1 || ok412 != 1 {
+		t.Fatalf("expected exactly one 200 and one 412, got codes %d and %d", a, b)
+	}
+}
+
+func TestHandlePatchMenuItem(t *testing.T) {
+	initial := []app.MenuItem{{ID: "1", Dish: "Stuffing", Who: "Pat"}}
+	initialData, _ := json.Marshal(initial)
+	menuPath := setupTestMenuFile(t, initialData)
+	server := app.New(menuPath)
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/menu?id=1", strings.NewReader(`{"who":"Jordan"}`))
+	rr := httptest.NewRecorder()
+	server.HandleMenu(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+
+	var menu []app.MenuItem
+	if err := json.Unmarshal(rr.Body.Bytes(), &menu); err != nil {
+		t.Fatalf("failed to decode patch response: %v", err)
+	}
+	if len(menu) != 1 || menu[0].Dish != "Stuffing" || menu[0].Who != "Jordan" {
+		t.Fatalf("unexpected menu after patch: %#v", menu)
+	}
+}
+
+func TestHandlePatchMenuItemNotFound(t *testing.T) {
+	menuPath := setupTestMenuFile(t, []byte("[]"))
+	server := app.New(menuPath)
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/menu?id=missing", strings.NewReader(`{"who":"Jordan"}`))
+	rr := httptest.NewRecorder()
+	server.HandleMenu(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rr.Code)
+	}
+}
+
+func TestWebSocketMenuSendsInitialAndAcceptsAdd(t *testing.T) {
+	menuPath := setupTestMenuFile(t, []byte("[]"))
+	server := app.New(menuPath)
+	server.SetWebSocketPingInterval(time.Hour)
+
+	ts := httptest.NewServer(http.HandlerFunc(server.WebSocketMenu))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to dial websocket: %v", err)
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	readMenuEnvelope := func() []app.MenuItem {
+		t.Helper()
+		_, frame, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("failed to read frame: %v", err)
+		}
+		var env struct {
+			Type    string          `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := json.Unmarshal(frame, &env); err != nil {
+			t.Fatalf("failed to decode envelope: %v", err)
+		}
+		if env.Type != "menu" {
+			t.Fatalf(`expected envelope type "menu", got %q`, env.Type)
+		}
+		var menu []app.MenuItem
+		if err := json.Unmarshal(env.Payload, &menu); err != nil {
+			t.Fatalf("failed to decode menu payload: %v", err)
+		}
+		return menu
+	}
+
+	if menu := readMenuEnvelope(); len(menu) != 0 {
+		t.Fatalf("expected empty initial menu, got %#v", menu)
+	}
+
+	if err := conn.Write(ctx, websocket.MessageText, []byte(`{"type":"add","payload":{"dish":"Yams","who":"Ari"}}`)); err != nil {
+		t.Fatalf("failed to write add envelope: %v", err)
+	}
+
+	menu := readMenuEnvelope()
+	if len(menu) != 1 || menu[0].Dish != "Yams" {
+		t.Fatalf("unexpected menu after add envelope: %#v", menu)
+	}
+}
+
 // flushRecorder adapts httptest.ResponseRecorder to implement http.Flusher.
 type flushRecorder struct {
 	*httptest.ResponseRecorder
@@ -0,0 +1,147 @@
+package app_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"friendsgiving/src/app"
+)
+
+// TestMenuLifecycleAcrossBackends exercises the same HTTP behavior against
+// both Store implementations, since App talks to storage exclusively
+// through the Store interface and shouldn't care which one is behind it.
+func TestMenuLifecycleAcrossBackends(t *testing.T) {
+	backends := []struct {
+		name string
+		ext  string
+	}{
+		{"json", "menu.json"},
+		{"sqlite", "menu.db"},
+	}
+
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			menuPath := filepath.Join(t.TempDir(), b.ext)
+			server := app.New(menuPath)
+
+			getReq := httptest.NewRequest(http.MethodGet, "/api/menu", nil)
+			getRR := httptest.NewRecorder()
+			server.HandleMenu(getRR, getReq)
+			if getRR.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", getRR.Code)
+			}
+			var seeded []app.MenuItem
+			if err := json.Unmarshal(getRR.Body.Bytes(), &seeded); err != nil {
+				t.Fatalf("failed to decode seeded menu: %v", err)
+			}
+			if len(seeded) == 0 {
+				t.Fatalf("expected New to seed a default menu")
+			}
+			if etag := getRR.Header().Get("ETag"); etag != `W/"r0"` {
+				t.Fatalf(`expected ETag W/"r0" before any mutation, got %q`, etag)
+			}
+
+			addReq := httptest.NewRequest(http.MethodPost, "/api/menu", strings.NewReader(`{"dish":"Cornbread","who":"Jess"}`))
+			addReq.Header.Set("Content-Type", "application/json")
+			addRR := httptest.NewRecorder()
+			server.HandleMenu(addRR, addReq)
+			if addRR.Code != http.StatusCreated {
+				t.Fatalf("expected 201, got %d", addRR.Code)
+			}
+
+			delReq := httptest.NewRequest(http.MethodDelete, "/api/menu?id="+seeded[0].ID, nil)
+			delRR := httptest.NewRecorder()
+			server.HandleMenu(delRR, delReq)
+			if delRR.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", delRR.Code)
+			}
+
+			// Reopening the same path should see everything persisted so far.
+			reopened := app.New(menuPath)
+			reopenReq := httptest.NewRequest(http.MethodGet, "/api/menu", nil)
+			reopenRR := httptest.NewRecorder()
+			reopened.HandleMenu(reopenRR, reopenReq)
+
+			var menu []app.MenuItem
+			if err := json.Unmarshal(reopenRR.Body.Bytes(), &menu); err != nil {
+				t.Fatalf("failed to decode reopened menu: %v", err)
+			}
+			if len(menu) != len(seeded) {
+				t.Fatalf("expected %d items after reopening, got %#v", len(seeded), menu)
+			}
+			for _, item := range menu {
+				if item.ID == seeded[0].ID {
+					t.Fatalf("expected deleted item %q to stay gone after reopening", seeded[0].ID)
+				}
+			}
+			var foundCornbread bool
+			for _, item := range menu {
+				if item.Dish == "Cornbread" {
+					foundCornbread = true
+				}
+			}
+			if !foundCornbread {
+				t.Fatalf("expected added item to survive reopening, got %#v", menu)
+			}
+		})
+	}
+}
+
+// TestConcurrentDeletesOneSucceedsOneFailsAcrossBackends is the same race
+// check as TestConcurrentDeletesOneSucceedsOneFails, run against both
+// backends: Store.Apply must serialize mutations so exactly one of two
+// concurrent If-Match deletes wins.
+func TestConcurrentDeletesOneSucceedsOneFailsAcrossBackends(t *testing.T) {
+	backends := []string{"menu.json", "menu.db"}
+
+	for _, ext := range backends {
+		t.Run(ext, func(t *testing.T) {
+			menuPath := filepath.Join(t.TempDir(), ext)
+			server := app.New(menuPath)
+
+			getReq := httptest.NewRequest(http.MethodGet, "/api/menu", nil)
+			getRR := httptest.NewRecorder()
+			server.HandleMenu(getRR, getReq)
+			var menu []app.MenuItem
+			if err := json.Unmarshal(getRR.Body.Bytes(), &menu); err != nil {
+				t.Fatalf("failed to decode menu: %v", err)
+			}
+			if len(menu) < 2 {
+				t.Fatalf("expected at least two seeded items, got %#v", menu)
+			}
+			etag := getRR.Header().Get("ETag")
+
+			codes := make(chan int, 2)
+			del := func(id string) {
+				req := httptest.NewRequest(http.MethodDelete, "/api/menu?id="+id, nil)
+				req.Header.Set("If-Match", etag)
+				rr := httptest.NewRecorder()
+				server.HandleMenu(rr, req)
+				codes <- rr.Code
+			}
+
+			go del(menu[0].ID)
+			go del(menu[1].ID)
+
+			a, b := <-codes, <-codes
+			ok200, ok412 := 0, 0
+			for _, code := range []int{a, b} {
+				switch code {
+				case http.StatusOK:
+					ok200++
+				case http.StatusPreconditionFailed:
+					ok412++
+				default:
+					t.Fatalf("unexpected status %d", code)
+				}
+			}
+			if ok200 != 1 || ok412 != 1 {
+				t.Fatalf("expected exactly one 200 and one 412, got codes %d and %d", a, b)
+			}
+		})
+	}
+}